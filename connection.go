@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
@@ -15,33 +16,72 @@ type StreamHandler func(interface{}) error
 type Connection struct {
 	pool        *GRPCPool
 	connection  *grpc.ClientConn
+	createdTime time.Time
 	updatedTime time.Time
 	streams     *sync.Map
+
+	// openStreams tracks how many named streams are currently open on this
+	// connection so the pool can cap concurrent multiplexing per conn.
+	openStreams int32
+
+	// busy reports whether this connection currently lives in the pool's
+	// busy list (openStreams has reached Options.MaxStreams).
+	busy bool
+
+	// prev/next link this connection into one of the pool's idle/busy
+	// intrusive lists. list records which one, nil if the connection
+	// currently belongs to neither, so connList.remove can tell whether a
+	// connection is actually still a member instead of double-unlinking it.
+	prev *Connection
+	next *Connection
+	list *connList
 }
 
 func NewConnection(pool *GRPCPool, c *grpc.ClientConn) *Connection {
+	now := time.Now()
+
 	return &Connection{
 		pool:        pool,
 		connection:  c,
-		updatedTime: time.Now(),
+		createdTime: now,
+		updatedTime: now,
 		streams:     &sync.Map{},
 	}
 }
 
+// touch marks the connection as having just gone idle, for IdleTimeout
+// bookkeeping.
+func (connection *Connection) touch() {
+	connection.updatedTime = time.Now()
+}
+
+func (connection *Connection) incStreams() int32 {
+	return atomic.AddInt32(&connection.openStreams, 1)
+}
+
+func (connection *Connection) decStreams() int32 {
+	return atomic.AddInt32(&connection.openStreams, -1)
+}
+
+func (connection *Connection) getStreams() int32 {
+	return atomic.LoadInt32(&connection.openStreams)
+}
+
 func (connection *Connection) GetStream(name string, fn StreamHandler) error {
 
-	// Getting stream by connection
+	// Getting stream by connection. A cached stream was already counted as
+	// open by onStreamOpened below, so on success we return here rather
+	// than falling through and re-initializing (and re-counting) it.
 	val, ok := connection.streams.Load(name)
 	if ok {
 		err := fn(val)
 		if err == io.EOF {
 			connection.streams.Delete(name)
+			connection.pool.onStreamClosed(connection)
 			return connection.GetStream(name, fn)
 		}
 
-		if err != nil {
-			return err
-		}
+		return err
 	}
 
 	// Initialize stream for connection
@@ -62,6 +102,7 @@ func (connection *Connection) GetStream(name string, fn StreamHandler) error {
 	err = fn(stream)
 	if err != io.EOF {
 		connection.streams.Store(name, stream)
+		connection.pool.onStreamOpened(connection)
 	}
 
 	return err
@@ -0,0 +1,72 @@
+package grpc_connection_pool
+
+// connList is an intrusive doubly linked list of *Connection. Connections
+// are linked through their own prev/next fields, so moving a connection
+// between the idle and busy lists does not require any extra allocation.
+type connList struct {
+	head  *Connection
+	tail  *Connection
+	count int
+}
+
+// pushFront inserts c at the front of the list.
+func (l *connList) pushFront(c *Connection) {
+	c.prev = nil
+	c.next = l.head
+
+	if l.head != nil {
+		l.head.prev = c
+	}
+
+	l.head = c
+	if l.tail == nil {
+		l.tail = c
+	}
+
+	c.list = l
+	l.count++
+}
+
+// pushBack inserts c at the back of the list.
+func (l *connList) pushBack(c *Connection) {
+	c.next = nil
+	c.prev = l.tail
+
+	if l.tail != nil {
+		l.tail.next = c
+	}
+
+	l.tail = c
+	if l.head == nil {
+		l.head = c
+	}
+
+	c.list = l
+	l.count++
+}
+
+// remove unlinks c from the list. It is a no-op if c is not currently a
+// member of l (e.g. it was already removed by a concurrent caller), so
+// count never drifts from a duplicate decrement.
+func (l *connList) remove(c *Connection) {
+	if c.list != l {
+		return
+	}
+
+	if c.prev != nil {
+		c.prev.next = c.next
+	} else if l.head == c {
+		l.head = c.next
+	}
+
+	if c.next != nil {
+		c.next.prev = c.prev
+	} else if l.tail == c {
+		l.tail = c.prev
+	}
+
+	c.prev = nil
+	c.next = nil
+	c.list = nil
+	l.count--
+}
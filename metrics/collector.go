@@ -0,0 +1,98 @@
+// Package metrics adapts a GRPCPool's Stats into a prometheus.Collector so
+// callers can register pool visibility without wiring Options.OnEvent
+// themselves.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	grpcpool "github.com/cfsghost/grpc-connection-pool"
+)
+
+// Collector implements prometheus.Collector over a GRPCPool's Stats().
+type Collector struct {
+	pool *grpcpool.GRPCPool
+
+	activeConns        *prometheus.Desc
+	idleConns          *prometheus.Desc
+	inUseConns         *prometheus.Desc
+	totalDials         *prometheus.Desc
+	failedDials        *prometheus.Desc
+	totalStreamsOpened *prometheus.Desc
+	waitCount          *prometheus.Desc
+	waitDuration       *prometheus.Desc
+}
+
+// NewCollector creates a prometheus.Collector backed by pool.Stats().
+func NewCollector(pool *grpcpool.GRPCPool) *Collector {
+	return &Collector{
+		pool: pool,
+
+		activeConns: prometheus.NewDesc(
+			"grpc_connection_pool_active_conns",
+			"Number of connections currently dialed by the pool.",
+			nil, nil,
+		),
+		idleConns: prometheus.NewDesc(
+			"grpc_connection_pool_idle_conns",
+			"Number of idle connections in the pool.",
+			nil, nil,
+		),
+		inUseConns: prometheus.NewDesc(
+			"grpc_connection_pool_in_use_conns",
+			"Number of connections currently checked out of the pool.",
+			nil, nil,
+		),
+		totalDials: prometheus.NewDesc(
+			"grpc_connection_pool_dials_total",
+			"Total number of successful dial attempts.",
+			nil, nil,
+		),
+		failedDials: prometheus.NewDesc(
+			"grpc_connection_pool_dials_failed_total",
+			"Total number of failed dial attempts.",
+			nil, nil,
+		),
+		totalStreamsOpened: prometheus.NewDesc(
+			"grpc_connection_pool_streams_opened_total",
+			"Total number of named streams opened across all connections.",
+			nil, nil,
+		),
+		waitCount: prometheus.NewDesc(
+			"grpc_connection_pool_wait_count_total",
+			"Total number of Get/Pop acquisition attempts, including failed ones.",
+			nil, nil,
+		),
+		waitDuration: prometheus.NewDesc(
+			"grpc_connection_pool_wait_duration_seconds_total",
+			"Total time spent acquiring connections from the pool.",
+			nil, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.activeConns
+	ch <- c.idleConns
+	ch <- c.inUseConns
+	ch <- c.totalDials
+	ch <- c.failedDials
+	ch <- c.totalStreamsOpened
+	ch <- c.waitCount
+	ch <- c.waitDuration
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.pool.Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.activeConns, prometheus.GaugeValue, float64(stats.ActiveConns))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stats.IdleConns))
+	ch <- prometheus.MustNewConstMetric(c.inUseConns, prometheus.GaugeValue, float64(stats.InUseConns))
+	ch <- prometheus.MustNewConstMetric(c.totalDials, prometheus.CounterValue, float64(stats.TotalDials))
+	ch <- prometheus.MustNewConstMetric(c.failedDials, prometheus.CounterValue, float64(stats.FailedDials))
+	ch <- prometheus.MustNewConstMetric(c.totalStreamsOpened, prometheus.CounterValue, float64(stats.TotalStreamsOpened))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+}
@@ -0,0 +1,330 @@
+package grpc_connection_pool
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// ErrUnknownConnection is returned by GRPCMultiPool.Push when conn was not
+// checked out from this multi-pool via Get or Pop.
+var ErrUnknownConnection = errors.New("connection did not originate from this multi-pool")
+
+// Resolver returns the current set of backend addresses a GRPCMultiPool
+// should maintain a sub-pool for.
+type Resolver interface {
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+// staticResolver implements Resolver over a fixed address list.
+type staticResolver struct {
+	hosts []string
+}
+
+func (r *staticResolver) Resolve(ctx context.Context) ([]string, error) {
+	return r.hosts, nil
+}
+
+// GRPCMultiPool maintains one GRPCPool per backend address and dispatches
+// Get/Pop/Push/GetStream across them in round-robin order, giving
+// client-side load balancing across a fleet without depending on gRPC's
+// built-in balancer.
+type GRPCMultiPool struct {
+	options     *Options
+	dialOptions []grpc.DialOption
+	resolver    Resolver
+
+	mu    sync.RWMutex
+	pools map[string]*GRPCPool
+	order []string
+
+	counter uint32
+	origin  sync.Map // *grpc.ClientConn -> *GRPCPool, used to route Push back
+
+	streamInitializers sync.Map // name -> StreamInitializer, propagated to every sub-pool
+
+	resolveInterval time.Duration
+	stopCh          chan struct{}
+	closed          int32
+}
+
+// NewGRPCMultiPool creates a multi-pool over a fixed list of backend
+// addresses, dialing one GRPCPool per address.
+func NewGRPCMultiPool(hosts []string, options *Options, dialOptions ...grpc.DialOption) (*GRPCMultiPool, error) {
+	return newGRPCMultiPool(&staticResolver{hosts: hosts}, options, 0, dialOptions...)
+}
+
+// NewGRPCMultiPoolWithResolver creates a multi-pool whose backend address
+// list is periodically re-resolved via resolver every resolveInterval,
+// adding sub-pools for new addresses and draining sub-pools for addresses
+// that disappear.
+func NewGRPCMultiPoolWithResolver(resolver Resolver, options *Options, resolveInterval time.Duration, dialOptions ...grpc.DialOption) (*GRPCMultiPool, error) {
+	return newGRPCMultiPool(resolver, options, resolveInterval, dialOptions...)
+}
+
+func newGRPCMultiPool(resolver Resolver, options *Options, resolveInterval time.Duration, dialOptions ...grpc.DialOption) (*GRPCMultiPool, error) {
+
+	mp := &GRPCMultiPool{
+		options:         options,
+		dialOptions:     dialOptions,
+		resolver:        resolver,
+		pools:           make(map[string]*GRPCPool),
+		resolveInterval: resolveInterval,
+		stopCh:          make(chan struct{}),
+	}
+
+	hosts, err := resolver.Resolve(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mp.sync(hosts); err != nil {
+		return nil, err
+	}
+
+	if resolveInterval > 0 {
+		go mp.watch()
+	}
+
+	return mp, nil
+}
+
+// sync reconciles the sub-pool set with the given host list, dialing pools
+// for newly seen addresses and draining pools for addresses no longer
+// present.
+func (mp *GRPCMultiPool) sync(hosts []string) error {
+
+	wanted := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		wanted[host] = true
+	}
+
+	mp.mu.Lock()
+
+	var toAdd []string
+	for _, host := range hosts {
+		if _, ok := mp.pools[host]; !ok {
+			toAdd = append(toAdd, host)
+		}
+	}
+
+	var toDrain []*GRPCPool
+	for host, pool := range mp.pools {
+		if !wanted[host] {
+			delete(mp.pools, host)
+			toDrain = append(toDrain, pool)
+		}
+	}
+
+	mp.mu.Unlock()
+
+	for _, pool := range toDrain {
+		go drainPool(pool)
+	}
+
+	for _, host := range toAdd {
+		pool, err := NewGRPCPool(host, mp.options, mp.dialOptions...)
+		if err != nil {
+			return err
+		}
+
+		mp.streamInitializers.Range(func(name, initializer interface{}) bool {
+			pool.SetStreamInitializer(name.(string), initializer.(StreamInitializer))
+			return true
+		})
+
+		mp.mu.Lock()
+		mp.pools[host] = pool
+		mp.mu.Unlock()
+	}
+
+	mp.mu.Lock()
+	order := make([]string, 0, len(mp.pools))
+	for host := range mp.pools {
+		order = append(order, host)
+	}
+	sort.Strings(order)
+	mp.order = order
+	mp.mu.Unlock()
+
+	return nil
+}
+
+// drainPool closes a removed sub-pool's connections as they become idle,
+// leaving any connection still serving a stream alone until it finishes.
+// Once every connection has drained it calls pool.Close() so the sub-pool's
+// reaper goroutine stops instead of running forever.
+func drainPool(pool *GRPCPool) {
+	for {
+		pool.mu.Lock()
+		for c := pool.idle.head; c != nil; {
+			next := c.next
+			if c.getStreams() == 0 {
+				pool.idle.remove(c)
+				c.connection.Close()
+				pool.unref()
+			}
+			c = next
+		}
+		pool.mu.Unlock()
+
+		if pool.getConnectionCount() == 0 {
+			pool.Close()
+			return
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+func (mp *GRPCMultiPool) watch() {
+	ticker := time.NewTicker(mp.resolveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			hosts, err := mp.resolver.Resolve(context.Background())
+			if err != nil {
+				continue
+			}
+
+			mp.sync(hosts)
+		case <-mp.stopCh:
+			return
+		}
+	}
+}
+
+// Stop terminates the background re-resolution goroutine started for a
+// resolver-backed multi-pool. It is a no-op for a static host list. Stop
+// alone leaves every sub-pool, and its reaper goroutine, running; use Close
+// to shut the whole multi-pool down.
+func (mp *GRPCMultiPool) Stop() {
+	if !atomic.CompareAndSwapInt32(&mp.closed, 0, 1) {
+		return
+	}
+
+	if mp.resolveInterval > 0 {
+		close(mp.stopCh)
+	}
+}
+
+// Close stops the background re-resolution goroutine (if any) and closes
+// every sub-pool via GRPCPool.Close, so their reaper goroutines stop too.
+func (mp *GRPCMultiPool) Close() error {
+	mp.Stop()
+
+	mp.mu.RLock()
+	pools := make([]*GRPCPool, 0, len(mp.pools))
+	for _, pool := range mp.pools {
+		pools = append(pools, pool)
+	}
+	mp.mu.RUnlock()
+
+	for _, pool := range pools {
+		pool.Close()
+	}
+
+	return nil
+}
+
+// next picks the sub-pool for the next address in round-robin order.
+func (mp *GRPCMultiPool) next() (*GRPCPool, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	if len(mp.order) == 0 {
+		return nil, ErrUnavailable
+	}
+
+	idx := atomic.AddUint32(&mp.counter, 1)
+	host := mp.order[int(idx)%len(mp.order)]
+
+	return mp.pools[host], nil
+}
+
+// Get returns an available gRPC client from the next sub-pool in
+// round-robin order.
+func (mp *GRPCMultiPool) Get() (*grpc.ClientConn, error) {
+
+	pool, err := mp.next()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := pool.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	mp.origin.Store(conn, pool)
+
+	return conn, nil
+}
+
+// Pop returns an available gRPC client from the next sub-pool in
+// round-robin order; the client will not be reused before it is returned to
+// the pool with Push.
+func (mp *GRPCMultiPool) Pop() (*grpc.ClientConn, error) {
+
+	pool, err := mp.next()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := pool.Pop()
+	if err != nil {
+		return nil, err
+	}
+
+	mp.origin.Store(conn, pool)
+
+	return conn, nil
+}
+
+// Push returns a gRPC client to the sub-pool it was originally checked out
+// from. Get hands the same conn to multiple concurrent callers, so origin
+// is not cleared on Push; it is an error to Push a conn this multi-pool
+// never checked out, since without a known origin there is no safe
+// sub-pool to return it to — silently round-robining it could hand a conn
+// dialed to one host into a different host's idle list.
+func (mp *GRPCMultiPool) Push(conn *grpc.ClientConn) error {
+
+	val, ok := mp.origin.Load(conn)
+	if !ok {
+		return ErrUnknownConnection
+	}
+
+	return val.(*GRPCPool).Push(conn)
+}
+
+// SetStreamInitializer registers a named stream initializer on every current
+// and future sub-pool.
+func (mp *GRPCMultiPool) SetStreamInitializer(name string, initializer StreamInitializer) {
+	mp.streamInitializers.Store(name, initializer)
+
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	for _, pool := range mp.pools {
+		pool.SetStreamInitializer(name, initializer)
+	}
+}
+
+// GetStream acquires a connection from the next sub-pool in round-robin
+// order and runs fn against the named stream on it.
+func (mp *GRPCMultiPool) GetStream(name string, fn StreamHandler) error {
+
+	pool, err := mp.next()
+	if err != nil {
+		return err
+	}
+
+	return pool.GetStream(name, fn)
+}
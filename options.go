@@ -7,13 +7,78 @@ type Options struct {
 	InitCap     int
 	MaxCap      int
 	DialTimeout time.Duration
+
+	// MaxStreams is the maximum number of concurrent named streams (opened
+	// via GetStream) a single connection may have open before the pool
+	// prefers another connection. It does not apply to plain Get/Pop
+	// checkouts, which hand out a raw *grpc.ClientConn outside this
+	// accounting.
+	MaxStreams int
+
+	// MaxIdle is the maximum number of connections on the pool's idle list
+	// (connections below MaxStreams, including ones with streams still
+	// open). When a connection's last named stream closes while the idle
+	// list holds more than MaxIdle connections, that connection is closed.
+	MaxIdle int
+
+	// KeepAliveTime is how often the pool pings an idle connection to keep
+	// it alive and detect dead peers. Zero leaves gRPC's default in place.
+	KeepAliveTime time.Duration
+
+	// KeepAliveTimeout is how long the pool waits for a keepalive ping ack
+	// before considering the connection dead.
+	KeepAliveTimeout time.Duration
+
+	// PermitWithoutStream allows keepalive pings even when a connection has
+	// no active RPCs.
+	PermitWithoutStream bool
+
+	// BackoffMaxDelay caps the exponential backoff between connection
+	// attempts. Zero leaves gRPC's default in place.
+	BackoffMaxDelay time.Duration
+
+	// InitialWindowSize sets the initial HTTP/2 stream flow control window,
+	// in bytes. Zero leaves gRPC's default in place.
+	InitialWindowSize int32
+
+	// InitialConnWindowSize sets the initial HTTP/2 connection flow control
+	// window, in bytes. Zero leaves gRPC's default in place.
+	InitialConnWindowSize int32
+
+	// MaxRecvMsgSize caps the size, in bytes, of a message the pool's
+	// connections will accept. Zero leaves gRPC's default in place.
+	MaxRecvMsgSize int
+
+	// MaxSendMsgSize caps the size, in bytes, of a message the pool's
+	// connections will send. Zero leaves gRPC's default in place.
+	MaxSendMsgSize int
+
+	// MaxLifetime is the maximum age of a connection before the reaper
+	// closes and recycles it. Zero means connections never expire by age.
+	MaxLifetime time.Duration
+
+	// IdleTimeout is the maximum time a connection may sit idle before the
+	// reaper closes it. Zero means idle connections never expire. In both
+	// cases at least InitCap connections are always kept alive.
+	IdleTimeout time.Duration
+
+	// AcquireTimeout bounds how long Get/Pop/GetStream will wait for a
+	// connection to become Ready before giving up.
+	AcquireTimeout time.Duration
+
+	// OnEvent, if set, is called on dial, close, checkout, checkin,
+	// health-check failure, and stream open/close.
+	OnEvent func(Event)
 }
 
 // NewOptions creates a Options object.
 func NewOptions() *Options {
 	return &Options{
-		InitCap:     8,
-		MaxCap:      128,
-		DialTimeout: 10 * time.Second,
+		InitCap:        8,
+		MaxCap:         128,
+		DialTimeout:    10 * time.Second,
+		MaxStreams:     100,
+		MaxIdle:        8,
+		AcquireTimeout: 5 * time.Second,
 	}
 }
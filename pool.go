@@ -3,24 +3,49 @@ package grpc_connection_pool
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/keepalive"
 )
 
 // ErrExceeded is the error when maximum number of connections exceeded
 var ErrExceeded = errors.New("Maximum number of connections exceeded")
 var ErrUnavailable = errors.New("No available connection")
 
+// ErrClosed is the error returned when the pool has been closed.
+var ErrClosed = errors.New("Pool is closed")
+
+// reaperInterval is how often the background reaper checks connections for
+// MaxLifetime/IdleTimeout expiry.
+const reaperInterval = 30 * time.Second
+
 type GRPCPool struct {
-	host               string
-	options            *Options
-	dialOptions        []grpc.DialOption
-	connections        chan *Connection
+	host        string
+	options     *Options
+	dialOptions []grpc.DialOption
+
+	mu   sync.Mutex
+	idle *connList
+	busy *connList
+
 	connCount          uint32
 	streamInitializers sync.Map
+
+	closed  int32
+	closeCh chan struct{}
+
+	// Counters backing Stats(), all updated with atomic so Stats() can read
+	// them from any goroutine without taking mu.
+	totalDials         uint64
+	failedDials        uint64
+	totalStreamsOpened uint64
+	waitCount          uint64
+	waitDuration       int64 // nanoseconds
 }
 
 // NewGRPCPool creates a new connection pool.
@@ -30,8 +55,10 @@ func NewGRPCPool(host string, options *Options, dialOptions ...grpc.DialOption)
 		host:        host,
 		options:     options,
 		dialOptions: dialOptions,
-		connections: make(chan *Connection, options.MaxCap),
+		idle:        &connList{},
+		busy:        &connList{},
 		connCount:   0,
+		closeCh:     make(chan struct{}),
 	}
 
 	err := pool.init()
@@ -39,6 +66,8 @@ func NewGRPCPool(host string, options *Options, dialOptions ...grpc.DialOption)
 		return nil, err
 	}
 
+	go pool.runReaper()
+
 	return pool, nil
 }
 
@@ -53,7 +82,9 @@ func (pool *GRPCPool) init() error {
 			return err
 		}
 
-		pool.connections <- NewConnection(pool, connection)
+		pool.mu.Lock()
+		pool.idle.pushFront(NewConnection(pool, connection))
+		pool.mu.Unlock()
 	}
 
 	return nil
@@ -93,140 +124,553 @@ func (pool *GRPCPool) factory() (*grpc.ClientConn, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), pool.options.DialTimeout)
 	defer cancel()
 
-	connection, err := grpc.DialContext(ctx, pool.host, pool.dialOptions...)
+	// Options-derived dial options come first so that any dial option the
+	// caller passed in explicitly wins on conflict.
+	dialOptions := append(pool.buildDialOptions(), pool.dialOptions...)
+
+	connection, err := grpc.DialContext(ctx, pool.host, dialOptions...)
 	if err != nil {
 		pool.unref()
+		atomic.AddUint64(&pool.failedDials, 1)
+		pool.emit(EventDialFailed)
 		return nil, err
 	}
 
+	atomic.AddUint64(&pool.totalDials, 1)
+	pool.emit(EventDial)
+
 	return connection, nil
 }
 
-func (pool *GRPCPool) checkConnectionState(connection *grpc.ClientConn) bool {
+// buildDialOptions translates the keepalive/backoff/message-size fields on
+// Options into the matching grpc.DialOptions.
+func (pool *GRPCPool) buildDialOptions() []grpc.DialOption {
+
+	var dialOptions []grpc.DialOption
+
+	if pool.options.KeepAliveTime > 0 || pool.options.KeepAliveTimeout > 0 || pool.options.PermitWithoutStream {
+		dialOptions = append(dialOptions, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                pool.options.KeepAliveTime,
+			Timeout:             pool.options.KeepAliveTimeout,
+			PermitWithoutStream: pool.options.PermitWithoutStream,
+		}))
+	}
+
+	if pool.options.BackoffMaxDelay > 0 {
+		dialOptions = append(dialOptions, grpc.WithBackoffMaxDelay(pool.options.BackoffMaxDelay))
+	}
+
+	if pool.options.InitialWindowSize > 0 {
+		dialOptions = append(dialOptions, grpc.WithInitialWindowSize(pool.options.InitialWindowSize))
+	}
+
+	if pool.options.InitialConnWindowSize > 0 {
+		dialOptions = append(dialOptions, grpc.WithInitialConnWindowSize(pool.options.InitialConnWindowSize))
+	}
+
+	var callOptions []grpc.CallOption
+
+	if pool.options.MaxRecvMsgSize > 0 {
+		callOptions = append(callOptions, grpc.MaxCallRecvMsgSize(pool.options.MaxRecvMsgSize))
+	}
+
+	if pool.options.MaxSendMsgSize > 0 {
+		callOptions = append(callOptions, grpc.MaxCallSendMsgSize(pool.options.MaxSendMsgSize))
+	}
+
+	if len(callOptions) > 0 {
+		dialOptions = append(dialOptions, grpc.WithDefaultCallOptions(callOptions...))
+	}
 
-	state := connection.GetState()
+	return dialOptions
+}
 
-	if state == connectivity.Shutdown || state == connectivity.TransientFailure {
+// connectionIsDead reports whether connection has been shut down and should
+// be dropped from the pool outright. It is used by code paths, such as
+// Push, that only need a cheap non-blocking sanity check.
+func (pool *GRPCPool) connectionIsDead(connection *grpc.ClientConn) bool {
 
-		// this connection doesn't work
+	if connection.GetState() == connectivity.Shutdown {
 		connection.Close()
 		pool.unref()
+		pool.emit(EventClose)
 
-		return false
+		return true
 	}
 
-	return true
+	return false
 }
 
-func (pool *GRPCPool) get() (*Connection, error) {
+// awaitReady waits, bounded by ctx, for connection to reach Ready. While
+// Connecting or Idle it simply waits out the state change; while in
+// TransientFailure it calls ResetConnectBackoff to force an immediate
+// reconnect attempt rather than discarding the conn and dialing a fresh one,
+// which preserves the subchannel's HTTP/2 settings and avoids a
+// thundering-herd of reconnects when a backend blips. It reports whether the
+// connection became ready, and separately whether it should be discarded
+// from the pool outright (e.g. because it was shut down).
+func (pool *GRPCPool) awaitReady(ctx context.Context, connection *grpc.ClientConn) (ready bool, discard bool) {
+
+	for {
+		state := connection.GetState()
+
+		switch state {
+		case connectivity.Ready:
+			return true, false
+
+		case connectivity.Shutdown:
+			connection.Close()
+			pool.unref()
+			pool.emit(EventHealthCheckFailed)
+
+			return false, true
+
+		case connectivity.TransientFailure:
+			connection.ResetConnectBackoff()
+		}
+
+		if !connection.WaitForStateChange(ctx, state) {
+			// AcquireTimeout elapsed before the state changed
+			return false, false
+		}
+	}
+}
+
+// onStreamOpened is called whenever a new named stream is created on a
+// connection. Once the connection reaches Options.MaxStreams it is moved
+// from the idle list to the busy list so future acquisitions prefer other
+// connections.
+func (pool *GRPCPool) onStreamOpened(connection *Connection) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	count := connection.incStreams()
+	atomic.AddUint64(&pool.totalStreamsOpened, 1)
+	pool.emit(EventStreamOpened)
+
+	if !connection.busy && count >= int32(pool.options.MaxStreams) {
+		pool.idle.remove(connection)
+		connection.busy = true
+		pool.busy.pushFront(connection)
+	}
+}
+
+// onStreamClosed is called whenever a named stream on a connection is torn
+// down. The connection moves back from busy to idle once it has spare
+// capacity again, and once its last stream has closed it is closed outright
+// if the idle list holds more than Options.MaxIdle connections.
+func (pool *GRPCPool) onStreamClosed(connection *Connection) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	count := connection.decStreams()
+	if count < 0 {
+		count = 0
+	}
+
+	pool.emit(EventStreamClosed)
+
+	if connection.busy && count < int32(pool.options.MaxStreams) {
+		pool.busy.remove(connection)
+		connection.busy = false
+		connection.touch()
+		pool.idle.pushFront(connection)
+	}
+
+	if count == 0 && !connection.busy && pool.idle.count > pool.options.MaxIdle {
+		pool.idle.remove(connection)
+		connection.connection.Close()
+		pool.unref()
+		pool.emit(EventClose)
+	}
+}
+
+// getForStream picks a connection that has spare capacity to open one more
+// named stream, checking each idle candidate's own stream count rather than
+// assuming the idle list invariant holds, dialing a new one if needed and
+// falling back to an over-subscribed busy connection only once MaxCap has
+// been reached.
+func (pool *GRPCPool) getForStream() (*Connection, error) {
+
+	if pool.isClosed() {
+		return nil, ErrClosed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pool.options.AcquireTimeout)
+	defer cancel()
 
 	for {
+		// Reserve the candidate under mu before waiting on it, so two
+		// concurrent GetStream callers can never race on the same
+		// *Connection and double-close/double-unref it (see awaitReady).
+		pool.mu.Lock()
+		c := pool.idle.head
+		for c != nil && c.getStreams() >= int32(pool.options.MaxStreams) {
+			c = c.next
+		}
+		if c != nil {
+			pool.idle.remove(c)
+		}
+		pool.mu.Unlock()
+
+		if c == nil {
+			break
+		}
+
+		ready, discard := pool.awaitReady(ctx, c.connection)
+		if ready {
+			pool.mu.Lock()
+			pool.idle.pushFront(c)
+			pool.mu.Unlock()
+			return c, nil
+		}
+
+		if discard {
+			// awaitReady already closed the connection and unref'd the
+			// pool; it must not be requeued.
+			continue
+		}
+
+		// AcquireTimeout elapsed while waiting for a connection to become
+		// ready; hand it back unchanged for the next caller.
+		pool.mu.Lock()
+		pool.idle.pushFront(c)
+		pool.mu.Unlock()
+
+		return nil, fmt.Errorf("acquiring connection for stream: %w", context.DeadlineExceeded)
+	}
+
+	// No idle connection has spare stream capacity, try dialing a new one
+	connection, err := pool.factory()
+	if err == nil {
+		c := NewConnection(pool, connection)
+
+		pool.mu.Lock()
+		pool.idle.pushFront(c)
+		pool.mu.Unlock()
+
+		return c, nil
+	}
+
+	if err != ErrExceeded {
+		return nil, err
+	}
+
+	// MaxCap reached, promote a busy connection rather than failing outright
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if pool.busy.head != nil {
+		return pool.busy.head, nil
+	}
 
+	if pool.idle.head != nil {
+		return pool.idle.head, nil
+	}
+
+	return nil, ErrUnavailable
+}
+
+// runReaper periodically closes connections that have exceeded MaxLifetime
+// or IdleTimeout, always keeping at least InitCap connections alive. It
+// stops once the pool is closed.
+func (pool *GRPCPool) runReaper() {
+	ticker := time.NewTicker(reaperInterval)
+	defer ticker.Stop()
+
+	for {
 		select {
-		case c := <-pool.connections:
+		case <-ticker.C:
+			pool.reap()
+		case <-pool.closeCh:
+			return
+		}
+	}
+}
 
-			// Getting connection from buffered channel
-			if !pool.checkConnectionState(c.connection) {
-				continue
+func (pool *GRPCPool) reap() {
+	now := time.Now()
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for c := pool.idle.head; c != nil; {
+		next := c.next
+
+		if pool.idle.count+pool.busy.count <= pool.options.InitCap {
+			break
+		}
+
+		expired := pool.options.MaxLifetime > 0 && now.Sub(c.createdTime) > pool.options.MaxLifetime
+		stale := pool.options.IdleTimeout > 0 && now.Sub(c.updatedTime) > pool.options.IdleTimeout
+
+		if expired || stale {
+			pool.idle.remove(c)
+			c.connection.Close()
+			pool.unref()
+			pool.emit(EventClose)
+		}
+
+		c = next
+	}
+}
+
+// Close shuts the pool down: it stops the reaper, closes every connection
+// currently held by the pool, and makes subsequent Get/Pop calls fail with
+// ErrClosed.
+func (pool *GRPCPool) Close() error {
+
+	if !atomic.CompareAndSwapInt32(&pool.closed, 0, 1) {
+		return nil
+	}
+
+	close(pool.closeCh)
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	for c := pool.idle.head; c != nil; c = c.next {
+		c.connection.Close()
+		pool.unref()
+		pool.emit(EventClose)
+	}
+
+	for c := pool.busy.head; c != nil; c = c.next {
+		c.connection.Close()
+		pool.unref()
+		pool.emit(EventClose)
+	}
+
+	pool.idle = &connList{}
+	pool.busy = &connList{}
+
+	return nil
+}
+
+func (pool *GRPCPool) isClosed() bool {
+	return atomic.LoadInt32(&pool.closed) == 1
+}
+
+// acquireIdle reserves and returns an idle connection, bounded by ctx.
+// Among the idle conns it prefers one that is already Ready over blocking
+// on whichever happens to be at the head, so a single slow (Connecting or
+// TransientFailure) connection can't shadow others that are ready to serve
+// right now. If it does have to wait and ctx's deadline elapses first, the
+// conn is requeued at the back of the idle list, not the front, so it isn't
+// the first one retried next time. requeue controls whether a conn found
+// Ready is put back on the idle list (true for Get, which hands out a
+// conn for concurrent reuse; false for Pop, which checks one out
+// exclusively until it is explicitly Pushed back).
+func (pool *GRPCPool) acquireIdle(ctx context.Context, requeue bool) (*Connection, error) {
+	for {
+		pool.mu.Lock()
+		c := pool.idle.head
+		for n := pool.idle.head; n != nil; n = n.next {
+			if n.connection.GetState() == connectivity.Ready {
+				c = n
+				break
 			}
+		}
+
+		if c != nil {
+			pool.idle.remove(c)
+		}
+		pool.mu.Unlock()
+
+		if c == nil {
+			return nil, nil
+		}
 
-			// Put connection back to pool immediately
-			pool.connections <- c
+		// Reserving the node under mu before waiting on it means two
+		// concurrent callers can never race on the same *Connection and
+		// double-close/double-unref it (see awaitReady).
+		ready, discard := pool.awaitReady(ctx, c.connection)
 
+		if ready {
+			if requeue {
+				pool.mu.Lock()
+				pool.idle.pushFront(c)
+				pool.mu.Unlock()
+			}
 			return c, nil
-		default:
+		}
+
+		if discard {
+			// awaitReady already closed the connection and unref'd the
+			// pool; it must not be requeued.
+			continue
+		}
+
+		// AcquireTimeout elapsed while waiting for this connection to
+		// become ready; requeue it at the back so it doesn't keep
+		// shadowing idle conns that may already be ready.
+		pool.mu.Lock()
+		pool.idle.pushBack(c)
+		pool.mu.Unlock()
+
+		return nil, fmt.Errorf("acquiring connection: %w", context.DeadlineExceeded)
+	}
+}
+
+func (pool *GRPCPool) get() (*Connection, error) {
 
-			// No available connection, so creating a new connection
-			c, err := pool.factory()
-			if err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), pool.options.AcquireTimeout)
+	defer cancel()
+
+	for {
+		if pool.isClosed() {
+			return nil, ErrClosed
+		}
+
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("acquiring connection: %w", context.DeadlineExceeded)
+		}
 
-				// Cannnot establish more connection
-				if err == ErrExceeded {
-					continue
-				}
+		c, err := pool.acquireIdle(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+
+		if c != nil {
+			return c, nil
+		}
 
-				if pool.getConnectionCount() == uint32(0) {
-					// No available connection
-					return nil, ErrUnavailable
-				}
+		// No available connection, so creating a new connection
+		dialed, err := pool.factory()
+		if err != nil {
 
+			// Cannnot establish more connection
+			if err == ErrExceeded {
 				continue
 			}
 
-			pool.Push(c)
+			if pool.getConnectionCount() == uint32(0) {
+				// No available connection
+				return nil, ErrUnavailable
+			}
+
+			continue
 		}
+
+		pool.Push(dialed)
 	}
 }
 
 func (pool *GRPCPool) pop() (*Connection, error) {
 
+	ctx, cancel := context.WithTimeout(context.Background(), pool.options.AcquireTimeout)
+	defer cancel()
+
 	for {
+		if pool.isClosed() {
+			return nil, ErrClosed
+		}
 
-		select {
-		case c := <-pool.connections:
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("acquiring connection: %w", context.DeadlineExceeded)
+		}
 
-			// Getting connection from buffered channel
-			if !pool.checkConnectionState(c.connection) {
-				continue
-			}
+		c, err := pool.acquireIdle(ctx, false)
+		if err != nil {
+			return nil, err
+		}
 
+		if c != nil {
 			return c, nil
-		default:
-
-			// No available connection, so creating a new connection
-			c, err := pool.factory()
-			if err != nil {
+		}
 
-				// Cannnot establish more connection
-				if err == ErrExceeded {
-					continue
-				}
+		// No available connection, so creating a new connection
+		dialed, err := pool.factory()
+		if err != nil {
 
-				if pool.getConnectionCount() == uint32(0) {
-					// No available connection
-					return nil, ErrUnavailable
-				}
+			// Cannnot establish more connection
+			if err == ErrExceeded {
+				continue
 			}
 
-			pool.Push(c)
+			if pool.getConnectionCount() == uint32(0) {
+				// No available connection
+				return nil, ErrUnavailable
+			}
 		}
+
+		pool.Push(dialed)
 	}
 }
 
 func (pool *GRPCPool) push(connection *Connection) error {
 
-	if !pool.checkConnectionState(connection.connection) {
+	if pool.isClosed() {
+		connection.connection.Close()
+		return ErrClosed
+	}
+
+	if pool.connectionIsDead(connection.connection) {
 		return nil
 	}
 
-	pool.connections <- connection
+	connection.touch()
+
+	pool.mu.Lock()
+	if connection.busy {
+		pool.busy.pushFront(connection)
+	} else {
+		pool.idle.pushFront(connection)
+	}
+	pool.mu.Unlock()
 
 	return nil
 }
 
-// Get will returns a available gRPC client.
+// Get will returns a available gRPC client. MaxStreams/MaxIdle and the busy
+// list only govern connections acquired through GetStream; Get takes a
+// connection from the idle list and hands out a raw *grpc.ClientConn for ad
+// hoc RPCs, and does not count against or consult a connection's named-stream
+// capacity.
 func (pool *GRPCPool) Get() (*grpc.ClientConn, error) {
 
+	start := time.Now()
+
 	conn, err := pool.get()
+
+	atomic.AddUint64(&pool.waitCount, 1)
+	atomic.AddInt64(&pool.waitDuration, int64(time.Since(start)))
+
 	if err != nil {
 		return nil, err
 	}
 
+	pool.emit(EventCheckout)
+
 	return conn.connection, nil
 }
 
 // Pop will return a availabe gRPC client and the gRPC client will not be reused before return client to the pool.
+// Like Get, it is exempt from MaxStreams/MaxIdle accounting: that only applies to connections acquired through GetStream.
 func (pool *GRPCPool) Pop() (*grpc.ClientConn, error) {
 
+	start := time.Now()
+
 	conn, err := pool.pop()
+
+	atomic.AddUint64(&pool.waitCount, 1)
+	atomic.AddInt64(&pool.waitDuration, int64(time.Since(start)))
+
 	if err != nil {
 		return nil, err
 	}
 
+	pool.emit(EventCheckout)
+
 	return conn.connection, nil
 }
 
 // Push will put gRPC client to the pool.
 func (pool *GRPCPool) Push(connection *grpc.ClientConn) error {
-	return pool.push(NewConnection(pool, connection))
+	err := pool.push(NewConnection(pool, connection))
+	if err == nil {
+		pool.emit(EventCheckin)
+	}
+
+	return err
 }
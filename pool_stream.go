@@ -15,7 +15,7 @@ func (pool *GRPCPool) GetStreamInitializer(name string) StreamInitializer {
 
 func (pool *GRPCPool) GetStream(name string, fn StreamHandler) error {
 
-	conn, err := pool.get()
+	conn, err := pool.getForStream()
 	if err != nil {
 		return err
 	}
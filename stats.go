@@ -0,0 +1,80 @@
+package grpc_connection_pool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies what happened to produce an Event passed to
+// Options.OnEvent.
+type EventType int
+
+const (
+	EventDial EventType = iota
+	EventDialFailed
+	EventClose
+	EventCheckout
+	EventCheckin
+	EventHealthCheckFailed
+	EventStreamOpened
+	EventStreamClosed
+)
+
+// Event is passed to Options.OnEvent whenever a notable pool lifecycle
+// moment happens.
+type Event struct {
+	Type EventType
+	Host string
+}
+
+// Stats reports a snapshot of a GRPCPool's connection and usage counters,
+// mirroring the shape of database/sql.DBStats.
+type Stats struct {
+	ActiveConns uint32
+	IdleConns   uint32
+	InUseConns  uint32
+
+	TotalDials         uint64
+	FailedDials        uint64
+	TotalStreamsOpened uint64
+
+	WaitCount    uint64
+	WaitDuration time.Duration
+}
+
+// emit calls Options.OnEvent, if set, for the given event type.
+func (pool *GRPCPool) emit(t EventType) {
+	if pool.options.OnEvent == nil {
+		return
+	}
+
+	pool.options.OnEvent(Event{Type: t, Host: pool.host})
+}
+
+// Stats returns a snapshot of the pool's connection and usage counters.
+func (pool *GRPCPool) Stats() Stats {
+
+	pool.mu.Lock()
+	idle := uint32(pool.idle.count)
+	pool.mu.Unlock()
+
+	active := pool.getConnectionCount()
+
+	var inUse uint32
+	if active > idle {
+		inUse = active - idle
+	}
+
+	return Stats{
+		ActiveConns: active,
+		IdleConns:   idle,
+		InUseConns:  inUse,
+
+		TotalDials:         atomic.LoadUint64(&pool.totalDials),
+		FailedDials:        atomic.LoadUint64(&pool.failedDials),
+		TotalStreamsOpened: atomic.LoadUint64(&pool.totalStreamsOpened),
+
+		WaitCount:    atomic.LoadUint64(&pool.waitCount),
+		WaitDuration: time.Duration(atomic.LoadInt64(&pool.waitDuration)),
+	}
+}